@@ -0,0 +1,95 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"context"
+
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/sessionctx"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+)
+
+// gcSubstituteHintSetters maps the /*+ ... */ hint names gcSubstituter understands to the
+// StmtHints field they set, so the hint parser can dispatch on a hint's name without a growing
+// case statement here. Keys are lower-cased, matching the hint's HintName.L they are looked up by.
+var gcSubstituteHintSetters = map[string]func(*stmtctx.StmtHints){
+	"sql_no_gc_subst": func(h *stmtctx.StmtHints) {
+		h.NoGCSubstitute = true
+	},
+	"sql_no_composite_gc_subst": func(h *stmtctx.StmtHints) {
+		h.NoCompositeGCSubstitute = true
+	},
+}
+
+// applyGCSubstituteHint applies the named hint to hints if it's one gcSubstituter recognizes,
+// reporting whether it was recognized so the caller knows whether to also check other rules' hint
+// tables.
+func applyGCSubstituteHint(hints *stmtctx.StmtHints, hintName string) bool {
+	setter, ok := gcSubstituteHintSetters[hintName]
+	if !ok {
+		return false
+	}
+	setter(hints)
+	return true
+}
+
+// PlanBuilder turns a parsed statement into a logical plan for the session that's building it.
+// This file only carries the statement-hint handling slice of it; buildSelect/buildUpdate/
+// buildDelete/etc. build the rest of the logical plan and live alongside the plan types they
+// construct.
+type PlanBuilder struct {
+	ctx sessionctx.Context
+}
+
+// NewPlanBuilder creates a PlanBuilder bound to the session building the current statement.
+func NewPlanBuilder(ctx sessionctx.Context) *PlanBuilder {
+	return &PlanBuilder{ctx: ctx}
+}
+
+// Build turns node into a logical plan. Any /*+ ... */ hints attached to the statement are applied
+// to the session's StmtHints before the plan itself is built, so every logical-optimize rule --
+// gcSubstituter among them -- sees the final StmtHints by the time it runs.
+func (b *PlanBuilder) Build(ctx context.Context, node ast.StmtNode) (LogicalPlan, error) {
+	switch x := node.(type) {
+	case *ast.SelectStmt:
+		b.handleStmtHints(x.TableHints)
+		return b.buildSelect(ctx, x)
+	case *ast.UpdateStmt:
+		b.handleStmtHints(x.TableHints)
+		return b.buildUpdate(ctx, x)
+	case *ast.DeleteStmt:
+		b.handleStmtHints(x.TableHints)
+		return b.buildDelete(ctx, x)
+	default:
+		return b.buildOther(ctx, node)
+	}
+}
+
+// handleStmtHints applies the hints in hints that this package recognizes (see
+// gcSubstituteHintSetters) to b.ctx's StmtHints. Hints it doesn't recognize are left alone for
+// whichever other rule registers its own setter for that name.
+func (b *PlanBuilder) handleStmtHints(hints []*ast.TableOptimizerHint) {
+	if len(hints) == 0 {
+		return
+	}
+	stmtHints := &b.ctx.GetSessionVars().StmtCtx.StmtHints
+	for _, hint := range hints {
+		if hint == nil {
+			continue
+		}
+		applyGCSubstituteHint(stmtHints, hint.HintName.L)
+	}
+}
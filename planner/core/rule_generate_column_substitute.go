@@ -15,73 +15,402 @@
 package core
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"sort"
 
+	"github.com/pingcap/errors"
 	"github.com/pingcap/tidb/expression"
+	"github.com/pingcap/tidb/parser"
 	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tidb/sessionctx"
 	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/statistics"
 	"github.com/pingcap/tidb/types"
 )
 
 type gcSubstituter struct {
 }
 
-// ExprColumnMap is used to store all expressions of indexed generated columns in a table,
-// and map them to the generated columns,
-// thus we can substitute the expression in a query to an indexed generated column.
-type ExprColumnMap map[expression.Expression]*expression.Column
+// ExprColumnMap maps the hash code of a normalized, indexed generated column expression to the
+// generated column it backs, so a query expression can be substituted after it is normalized and
+// hashed the same way, without a linear scan over every candidate.
+type ExprColumnMap map[string]*genColCandidate
 
-// optimize try to replace the expression to indexed virtual generate column in where, group by, order by, and field clause
-// so that we can use the index on expression.
+// genColCandidate is the value side of ExprColumnMap: the normalized generation expression paired
+// with the generated column it was derived from, plus what shouldSubstituteForCost needs to decide
+// whether the rewrite is actually worth it.
+type genColCandidate struct {
+	expr expression.Expression
+	col  *expression.Column
+
+	colInfo      *model.ColumnInfo
+	statsTbl     *statistics.Table
+	hasTablePath bool
+}
+
+// indexGenColumns is the ordered, leading run of generated-column substitution candidates backing
+// one candidate index, in index column order (non-generated index columns are skipped since they
+// need no substitution and don't interrupt the prefix). Only this leading run can ever make the
+// index usable, so gcSubstituter.substitute only tries to rewrite a prefix of it, see
+// substituteIndexPrefix.
+type indexGenColumns struct {
+	candidates []*genColCandidate
+}
+
+// optimize try to replace the expression to indexed generated column (virtual or stored) in where, group by, order by, join,
+// window, having, and field clause so that we can use the index on expression.
 // For example: select a+1 from t order by a+1, with a virtual generate column c as (a+1) and
 // an index on c. We need to replace a+1 with c so that we can use the index on c.
+// Every actual rewrite is still gated by shouldSubstituteForCost, so a generated column index with
+// poor selectivity, or without collected stats when a table path is already usable, is left alone.
 // See also https://dev.mysql.com/doc/refman/8.0/en/generated-column-index-optimizations.html
 func (gc *gcSubstituter) optimize(ctx context.Context, lp LogicalPlan, _ *logicalOptimizeOp) (LogicalPlan, error) {
 	exprToColumn := make(ExprColumnMap)
-	collectGenerateColumn(lp, exprToColumn)
+	var indexGenCols []*indexGenColumns
+	collectGenerateColumn(lp, exprToColumn, &indexGenCols)
 	if len(exprToColumn) == 0 {
 		return lp, nil
 	}
-	return gc.substitute(ctx, lp, exprToColumn), nil
+	return gc.substitute(ctx, lp, exprToColumn, indexGenCols), nil
 }
 
-// collectGenerateColumn collect the generate column and save them to a map from their expressions to themselves.
-// For the sake of simplicity, we don't collect the stored generate column because we can't get their expressions directly.
-// TODO: support stored generate column.
-func collectGenerateColumn(lp LogicalPlan, exprToColumn ExprColumnMap) {
+// collectGenerateColumn collect the generate column and save them to a map from the hash code of
+// their normalized expression to the generated column itself. It also groups the same candidates by
+// the index they back, in index column order, for substituteIndexPrefix to consume.
+// For virtual generated columns, the generation expression is already attached to the column by the
+// DDL layer (col.VirtualExpr). Stored generated columns don't carry such an expression, so we parse
+// colInfo.GeneratedExprString and rewrite it against the DataSource's schema to recover it.
+func collectGenerateColumn(lp LogicalPlan, exprToColumn ExprColumnMap, indexGenCols *[]*indexGenColumns) {
 	for _, child := range lp.Children() {
-		collectGenerateColumn(child, exprToColumn)
+		collectGenerateColumn(child, exprToColumn, indexGenCols)
 	}
 	ds, ok := lp.(*DataSource)
 	if !ok {
 		return
 	}
+	sc := ds.SCtx().GetSessionVars().StmtCtx
+	hasTablePath := false
+	for _, p := range ds.possibleAccessPaths {
+		if p.IsTablePath() {
+			hasTablePath = true
+			break
+		}
+	}
 	for _, p := range ds.possibleAccessPaths {
 		if p.IsTablePath() {
 			continue
 		}
+		var candidates []*genColCandidate
+		// Once a generated index column can't become a candidate, the later ones no longer extend a
+		// leading run: they still go into exprToColumn, but not into candidates.
+		prefixBroken := false
 		for _, idxPart := range p.Index.Columns {
 			colInfo := ds.tableInfo.Columns[idxPart.Offset]
-			if colInfo.IsGenerated() && !colInfo.GeneratedStored {
-				s := ds.schema.Columns
-				col := expression.ColInfo2Col(s, colInfo)
-				if col != nil && col.GetType().Equal(col.VirtualExpr.GetType()) {
-					exprToColumn[col.VirtualExpr] = col
+			if !colInfo.IsGenerated() {
+				continue
+			}
+			s := ds.schema.Columns
+			col := expression.ColInfo2Col(s, colInfo)
+			if col == nil {
+				prefixBroken = true
+				continue
+			}
+			var genExpr expression.Expression
+			if colInfo.GeneratedStored {
+				var err error
+				genExpr, err = rewriteGeneratedExprString(ds.SCtx(), colInfo.GeneratedExprString, ds.schema, ds.names)
+				if err != nil {
+					prefixBroken = true
+					continue
 				}
+				// RewriteAstExpr infers flen/decimal/collation from the expression tree, which
+				// routinely won't bit-for-bit match the DDL-declared column type even when the
+				// expression is exactly what generated the column. EvalType alone isn't a safe
+				// enough check though: the stored column's on-disk value is truncated/rounded to
+				// its declared decimal scale, string length, or time fsp, so a query predicate
+				// evaluated at the untruncated precision genExpr infers could mean something
+				// different than the same predicate compared against the generated column. See
+				// typeNarrowingCompatible.
+				if genExpr == nil || !typeNarrowingCompatible(genExpr.GetType(), col.GetType()) {
+					prefixBroken = true
+					continue
+				}
+			} else {
+				genExpr = col.VirtualExpr
+				if genExpr == nil || !genExpr.GetType().Equal(col.GetType()) {
+					prefixBroken = true
+					continue
+				}
+			}
+			// A generated column's own expression can reference another generated column (e.g.
+			// g2 int as (g1 * 2) stored where g1 int as (a + 1) virtual). Left alone, genExpr
+			// would normalize to a dangling reference to g1's column instead of g1's own
+			// expression, and would never hash-match a query predicate written in terms of the
+			// underlying base columns. Expand any such references before normalizing.
+			genExpr = resolveGeneratedColumnRefs(ds, genExpr, map[int64]struct{}{colInfo.ID: {}})
+			normalized := normalizeExpr(ds.SCtx(), sc, genExpr.Clone())
+			cand := &genColCandidate{
+				expr:         normalized,
+				col:          col,
+				colInfo:      colInfo,
+				statsTbl:     ds.statisticTable,
+				hasTablePath: hasTablePath,
 			}
+			exprToColumn[string(normalized.HashCode(sc))] = cand
+			if !prefixBroken {
+				candidates = append(candidates, cand)
+			}
+		}
+		if len(candidates) > 0 {
+			*indexGenCols = append(*indexGenCols, &indexGenColumns{candidates: candidates})
+		}
+	}
+}
+
+// typeNarrowingCompatible reports whether substituting an expression whose inferred type is from
+// can safely stand in for a generated column declared as to. EvalType alone isn't enough: a stored
+// column's on-disk value is truncated or rounded to its own declared decimal scale, string length,
+// or time fsp, so if from carries more precision than to in one of those fields, they can't be
+// assumed interchangeable even though their EvalType matches.
+func typeNarrowingCompatible(from, to *types.FieldType) bool {
+	if from.EvalType() != to.EvalType() {
+		return false
+	}
+	switch from.EvalType() {
+	case types.ETDecimal:
+		return from.GetDecimal() == to.GetDecimal()
+	case types.ETString:
+		return from.GetFlen() == to.GetFlen()
+	case types.ETDuration, types.ETDatetime, types.ETTimestamp:
+		return from.GetDecimal() == to.GetDecimal()
+	default:
+		return true
+	}
+}
+
+// resolveGeneratedColumnRefs recursively replaces any column in expr that is itself a generated
+// column with that column's own generation expression, so a chain of generated columns normalizes
+// down to the same base-column expression a query predicate written directly against the base
+// columns would. seen guards against a column (pathologically) referencing itself.
+func resolveGeneratedColumnRefs(ds *DataSource, expr expression.Expression, seen map[int64]struct{}) expression.Expression {
+	switch e := expr.(type) {
+	case *expression.Column:
+		colInfo := findColumnInfoByID(ds.tableInfo, e.ID)
+		if colInfo == nil || !colInfo.IsGenerated() {
+			return e
+		}
+		if _, ok := seen[colInfo.ID]; ok {
+			return e
+		}
+		refExpr, ok := genColumnExpr(ds, colInfo)
+		if !ok {
+			return e
+		}
+		seen[colInfo.ID] = struct{}{}
+		return resolveGeneratedColumnRefs(ds, refExpr.Clone(), seen)
+	case *expression.ScalarFunction:
+		args := e.GetArgs()
+		newArgs := make([]expression.Expression, len(args))
+		for i, arg := range args {
+			newArgs[i] = resolveGeneratedColumnRefs(ds, arg, seen)
 		}
+		return expression.NewFunctionInternal(ds.SCtx(), e.FuncName.L, e.GetType(), newArgs...)
+	default:
+		return expr
 	}
 }
 
-func tryToSubstituteExpr(expr *expression.Expression, sctx sessionctx.Context, candidateExpr expression.Expression, tp types.EvalType, schema *expression.Schema, col *expression.Column) {
-	if (*expr).Equal(sctx, candidateExpr) && candidateExpr.GetType().EvalType() == tp &&
-		schema.ColumnIndex(col) != -1 {
-		*expr = col
+// genColumnExpr returns colInfo's own generation expression (virtual or stored), unresolved and
+// unnormalized -- expanding any further generated-column references in it is
+// resolveGeneratedColumnRefs's job, not this function's.
+func genColumnExpr(ds *DataSource, colInfo *model.ColumnInfo) (expression.Expression, bool) {
+	col := expression.ColInfo2Col(ds.schema.Columns, colInfo)
+	if col == nil {
+		return nil, false
 	}
+	if colInfo.GeneratedStored {
+		genExpr, err := rewriteGeneratedExprString(ds.SCtx(), colInfo.GeneratedExprString, ds.schema, ds.names)
+		if err != nil || genExpr == nil {
+			return nil, false
+		}
+		return genExpr, true
+	}
+	if col.VirtualExpr == nil {
+		return nil, false
+	}
+	return col.VirtualExpr, true
+}
+
+// findColumnInfoByID looks up a column's definition in tblInfo by its unique ID.
+func findColumnInfoByID(tblInfo *model.TableInfo, id int64) *model.ColumnInfo {
+	for _, c := range tblInfo.Columns {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
 }
 
-func substituteExpression(cond expression.Expression, sctx *stmtctx.StatementContext, sessionCtx sessionctx.Context, exprToColumn ExprColumnMap, schema *expression.Schema) {
+// shouldSubstituteForCost reports whether rewriting an expression to cand's generated column is
+// likely to help rather than hurt, given the optimizer's statistics. Substituting in an index
+// access on a poorly-selective generated column can be worse than the plan the optimizer would
+// otherwise have picked, and a generated column with uncollected stats can't even be compared, so
+// this errs towards declining the rewrite in both cases.
+func shouldSubstituteForCost(sctx sessionctx.Context, cand *genColCandidate) bool {
+	if sctx.GetSessionVars().StmtCtx.StmtHints.NoGCSubstitute {
+		return false
+	}
+	if cand.statsTbl == nil || cand.statsTbl.Pseudo || cand.colInfo == nil {
+		return !cand.hasTablePath
+	}
+	colStats, ok := cand.statsTbl.Columns[cand.colInfo.ID]
+	if !ok || colStats.Histogram.NDV == 0 {
+		// No collected stats for the generated column: only risk the rewrite when there's no
+		// already-usable table path the optimizer could fall back to instead.
+		return !cand.hasTablePath
+	}
+	if cand.statsTbl.Count == 0 {
+		return true
+	}
+	threshold := sctx.GetSessionVars().OptGenerateColumnSubstituteSelectivityThreshold
+	estimatedRows := float64(cand.statsTbl.Count) / float64(colStats.Histogram.NDV)
+	return estimatedRows <= threshold*float64(cand.statsTbl.Count)
+}
+
+// rewriteGeneratedExprString parses a stored generated column's expression string and rewrites it
+// into an expression.Expression using the given schema, so it can be matched against expressions
+// appearing in the query the same way a virtual generated column's VirtualExpr is.
+func rewriteGeneratedExprString(sctx sessionctx.Context, exprStr string, schema *expression.Schema, names types.NameSlice) (expression.Expression, error) {
+	exprNode, err := parser.New().ParseOneStmt(fmt.Sprintf("select %s", exprStr), "", "")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	expr := exprNode.(*ast.SelectStmt).Fields.Fields[0].Expr
+	return expression.RewriteAstExpr(sctx, expr, schema, names)
+}
+
+// commutativeAssociativeFuncs are builtins for which normalizeExpr flattens nested chains (e.g.
+// (a+b)+c and a+(b+c)) into one n-ary list before sorting, so either nesting normalizes identically.
+var commutativeAssociativeFuncs = map[string]struct{}{
+	ast.Plus:     {},
+	ast.Mul:      {},
+	ast.LogicAnd: {},
+	ast.LogicOr:  {},
+}
+
+// commutativeFuncs are builtins whose two arguments can be swapped without changing the result, so
+// normalizeExpr sorts them into a stable order even though they aren't associative.
+var commutativeFuncs = map[string]struct{}{
+	ast.EQ: {},
+	ast.NE: {},
+}
+
+// canReassociate reports whether flattening and re-nesting a chain of funcName applications is
+// guaranteed to preserve the exact value. Integer and decimal +/* are associative, so any nesting
+// computes the same result. IEEE-754 float addition and multiplication are commutative but NOT
+// associative: (a+b)+c and a+(b+c) can round differently. Re-nesting such a chain into a new
+// grouping could make the normalized match-expression compute a value that differs from what the
+// original, left-to-right query expression would have evaluated to -- a silent wrong-result bug,
+// not just a missed optimization. So float chains fall back to the narrower same-nesting swap in
+// normalizeExpr instead of its flatten/re-nest path.
+func canReassociate(funcName string, retType *types.FieldType) bool {
+	switch funcName {
+	case ast.LogicAnd, ast.LogicOr:
+		return true
+	case ast.Plus, ast.Mul:
+		return retType.EvalType() != types.ETReal
+	}
+	return false
+}
+
+// normalizeExpr canonicalizes expr bottom-up so that expressions that are semantically equal up to
+// constant folding, no-op casts, commutativity, or associative re-nesting compare equal via
+// Expression.Equal (and hash identically via Expression.HashCode). It mutates expr's children
+// in place, so callers must pass a Clone() of any expression they don't own, such as a generated
+// column's VirtualExpr.
+func normalizeExpr(sctx sessionctx.Context, sc *stmtctx.StatementContext, expr expression.Expression) expression.Expression {
+	expr = expression.FoldConstant(expr)
+	sf, ok := expr.(*expression.ScalarFunction)
+	if !ok {
+		return expr
+	}
+	if sf.FuncName.L == ast.Cast && sf.GetArgs()[0].GetType().Equal(sf.GetType()) {
+		return normalizeExpr(sctx, sc, sf.GetArgs()[0])
+	}
+	args := sf.GetArgs()
+	for i := range args {
+		args[i] = normalizeExpr(sctx, sc, args[i])
+	}
+	switch {
+	case isIn(sf.FuncName.L, commutativeAssociativeFuncs) && canReassociate(sf.FuncName.L, sf.GetType()):
+		flat := flattenAssociative(sf.FuncName.L, args)
+		sortExprsByHash(flat, sc)
+		expr = renestLeftDeep(sctx, sf.FuncName.L, sf.GetType(), flat)
+	case isIn(sf.FuncName.L, commutativeAssociativeFuncs), isIn(sf.FuncName.L, commutativeFuncs):
+		// Either this chain's type makes re-nesting unsafe (see canReassociate), or the builtin is
+		// commutative but not associative to begin with (EQ, NE). Either way the two direct
+		// operands can still be swapped without changing the result, just not flattened across a
+		// nested chain.
+		sortExprsByHash(args, sc)
+	}
+	if rsf, ok := expr.(*expression.ScalarFunction); ok {
+		expression.ReHashCode(rsf, sc)
+	}
+	return expr
+}
+
+func isIn(name string, set map[string]struct{}) bool {
+	_, ok := set[name]
+	return ok
+}
+
+// flattenAssociative collects the leaves of a chain of funcName applications, e.g. flattening
+// (a+b)+c and a+(b+c) both into [a, b, c].
+func flattenAssociative(funcName string, args []expression.Expression) []expression.Expression {
+	flat := make([]expression.Expression, 0, len(args))
+	for _, arg := range args {
+		if sf, ok := arg.(*expression.ScalarFunction); ok && sf.FuncName.L == funcName {
+			flat = append(flat, flattenAssociative(funcName, sf.GetArgs())...)
+			continue
+		}
+		flat = append(flat, arg)
+	}
+	return flat
+}
+
+// renestLeftDeep rebuilds a flat operand list into a left-deep chain of funcName applications.
+func renestLeftDeep(sctx sessionctx.Context, funcName string, retType *types.FieldType, args []expression.Expression) expression.Expression {
+	result := args[0]
+	for _, arg := range args[1:] {
+		result = expression.NewFunctionInternal(sctx, funcName, retType, result, arg)
+	}
+	return result
+}
+
+func sortExprsByHash(exprs []expression.Expression, sc *stmtctx.StatementContext) {
+	sort.Slice(exprs, func(i, j int) bool {
+		return bytes.Compare(exprs[i].HashCode(sc), exprs[j].HashCode(sc)) < 0
+	})
+}
+
+// tryToSubstituteExpr normalizes *expr the same way the candidates in exprToColumn were normalized
+// and, on a hash match whose type and visibility line up, replaces it with the generated column.
+func tryToSubstituteExpr(expr *expression.Expression, sctx sessionctx.Context, sc *stmtctx.StatementContext, exprToColumn ExprColumnMap, tp types.EvalType, schema *expression.Schema) {
+	normalized := normalizeExpr(sctx, sc, (*expr).Clone())
+	candidate, ok := exprToColumn[string(normalized.HashCode(sc))]
+	if !ok {
+		return
+	}
+	if candidate.expr.GetType().EvalType() == tp && schema.ColumnIndex(candidate.col) != -1 &&
+		shouldSubstituteForCost(sctx, candidate) {
+		*expr = candidate.col
+	}
+}
+
+func substituteExpression(cond expression.Expression, sc *stmtctx.StatementContext, sctx sessionctx.Context, exprToColumn ExprColumnMap, schema *expression.Schema) {
 	sf, ok := cond.(*expression.ScalarFunction)
 	if !ok {
 		return
@@ -89,18 +418,14 @@ func substituteExpression(cond expression.Expression, sctx *stmtctx.StatementCon
 	defer func() {
 		// If the argument is not changed, hash code doesn't need to recount again.
 		// But we always do it to keep the code simple and stupid.
-		expression.ReHashCode(sf, sctx)
+		expression.ReHashCode(sf, sc)
 	}()
 	var expr *expression.Expression
 	var tp types.EvalType
 	switch sf.FuncName.L {
 	case ast.EQ, ast.LT, ast.LE, ast.GT, ast.GE:
-		for candidateExpr, column := range exprToColumn {
-			tryToSubstituteExpr(&sf.GetArgs()[1], sessionCtx, candidateExpr, sf.GetArgs()[0].GetType().EvalType(), schema, column)
-		}
-		for candidateExpr, column := range exprToColumn {
-			tryToSubstituteExpr(&sf.GetArgs()[0], sessionCtx, candidateExpr, sf.GetArgs()[1].GetType().EvalType(), schema, column)
-		}
+		tryToSubstituteExpr(&sf.GetArgs()[1], sctx, sc, exprToColumn, sf.GetArgs()[0].GetType().EvalType(), schema)
+		tryToSubstituteExpr(&sf.GetArgs()[0], sctx, sc, exprToColumn, sf.GetArgs()[1].GetType().EvalType(), schema)
 	case ast.In:
 		expr = &sf.GetArgs()[0]
 		tp = sf.GetArgs()[1].GetType().EvalType()
@@ -114,70 +439,133 @@ func substituteExpression(cond expression.Expression, sctx *stmtctx.StatementCon
 			}
 		}
 		if canSubstitute {
-			for candidateExpr, column := range exprToColumn {
-				tryToSubstituteExpr(expr, sessionCtx, candidateExpr, tp, schema, column)
-			}
+			tryToSubstituteExpr(expr, sctx, sc, exprToColumn, tp, schema)
 		}
 	case ast.Like:
 		expr = &sf.GetArgs()[0]
 		tp = sf.GetArgs()[1].GetType().EvalType()
-		for candidateExpr, column := range exprToColumn {
-			tryToSubstituteExpr(expr, sessionCtx, candidateExpr, tp, schema, column)
-		}
+		tryToSubstituteExpr(expr, sctx, sc, exprToColumn, tp, schema)
 	case ast.LogicOr, ast.LogicAnd:
-		substituteExpression(sf.GetArgs()[0], sctx, sessionCtx, exprToColumn, schema)
-		substituteExpression(sf.GetArgs()[1], sctx, sessionCtx, exprToColumn, schema)
+		substituteExpression(sf.GetArgs()[0], sc, sctx, exprToColumn, schema)
+		substituteExpression(sf.GetArgs()[1], sc, sctx, exprToColumn, schema)
 	case ast.UnaryNot:
-		substituteExpression(sf.GetArgs()[0], sctx, sessionCtx, exprToColumn, schema)
+		substituteExpression(sf.GetArgs()[0], sc, sctx, exprToColumn, schema)
 	}
 }
 
-func (gc *gcSubstituter) substitute(ctx context.Context, lp LogicalPlan, exprToColumn ExprColumnMap) LogicalPlan {
-	sctx := lp.SCtx().GetSessionVars().StmtCtx
+// substituteIndexPrefix greedily tries to substitute candidates, in index column order, against
+// x's own Conditions, stopping at the first candidate no condition matches. Because it stops there,
+// everything it substitutes before stopping is always a genuine usable prefix of the index (length
+// >= 1 when anything was substituted at all) -- a lone match on a non-leading column, which would
+// not make the index usable, is never committed on its own.
+func substituteIndexPrefix(x *LogicalSelection, sc *stmtctx.StatementContext, sctx sessionctx.Context, candidates []*genColCandidate) {
+	for _, cand := range candidates {
+		single := ExprColumnMap{string(cand.expr.HashCode(sc)): cand}
+		matched := false
+		for _, cond := range x.Conditions {
+			substituteExpression(cond, sc, sctx, single, x.Schema())
+			if exprContainsColumn(cond, cand.col) {
+				matched = true
+			}
+		}
+		if !matched {
+			return
+		}
+	}
+}
+
+// exprContainsColumn reports whether col appears anywhere in expr. It's used to detect that
+// substituteIndexPrefix's single-candidate substitution actually fired for a given condition.
+func exprContainsColumn(expr expression.Expression, col *expression.Column) bool {
+	switch e := expr.(type) {
+	case *expression.Column:
+		return e.UniqueID == col.UniqueID
+	case *expression.ScalarFunction:
+		for _, arg := range e.GetArgs() {
+			if exprContainsColumn(arg, col) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// enableCompositeGCSubstitute reports whether gcSubstituter may rewrite a Conditions slice against
+// a composite index's leading generated-column prefix for the current statement. It can be disabled
+// globally with the tidb_opt_enable_composite_gc_substitution session variable, or for a single
+// query with the SQL_NO_COMPOSITE_GC_SUBST hint.
+func enableCompositeGCSubstitute(sctx sessionctx.Context) bool {
+	vars := sctx.GetSessionVars()
+	if vars.StmtCtx.StmtHints.NoCompositeGCSubstitute {
+		return false
+	}
+	return vars.EnableCompositeGCSubstitution
+}
+
+func (gc *gcSubstituter) substitute(ctx context.Context, lp LogicalPlan, exprToColumn ExprColumnMap, indexGenCols []*indexGenColumns) LogicalPlan {
+	sc := lp.SCtx().GetSessionVars().StmtCtx
 	var tp types.EvalType
 	switch x := lp.(type) {
 	case *LogicalSelection:
-		for _, cond := range x.Conditions {
-			substituteExpression(cond, sctx, lp.SCtx(), exprToColumn, x.Schema())
+		if len(indexGenCols) > 0 && enableCompositeGCSubstitute(lp.SCtx()) {
+			for _, idx := range indexGenCols {
+				substituteIndexPrefix(x, sc, lp.SCtx(), idx.candidates)
+			}
+		} else {
+			for _, cond := range x.Conditions {
+				substituteExpression(cond, sc, lp.SCtx(), exprToColumn, x.Schema())
+			}
 		}
 	case *LogicalProjection:
 		for i := range x.Exprs {
 			tp = x.Exprs[i].GetType().EvalType()
-			for candidateExpr, column := range exprToColumn {
-				tryToSubstituteExpr(&x.Exprs[i], lp.SCtx(), candidateExpr, tp, x.children[0].Schema(), column)
-			}
+			tryToSubstituteExpr(&x.Exprs[i], lp.SCtx(), sc, exprToColumn, tp, x.children[0].Schema())
 		}
 	case *LogicalSort:
 		for i := range x.ByItems {
 			tp = x.ByItems[i].Expr.GetType().EvalType()
-			for candidateExpr, column := range exprToColumn {
-				tryToSubstituteExpr(&x.ByItems[i].Expr, lp.SCtx(), candidateExpr, tp, x.Schema(), column)
-			}
+			tryToSubstituteExpr(&x.ByItems[i].Expr, lp.SCtx(), sc, exprToColumn, tp, x.Schema())
 		}
 	case *LogicalAggregation:
 		for _, aggFunc := range x.AggFuncs {
 			for i := 0; i < len(aggFunc.Args); i++ {
 				tp = aggFunc.Args[i].GetType().EvalType()
-				for candidateExpr, column := range exprToColumn {
-					if aggFunc.Args[i].Equal(lp.SCtx(), candidateExpr) && candidateExpr.GetType().EvalType() == tp &&
-						x.Schema().ColumnIndex(column) != -1 {
-						aggFunc.Args[i] = column
-					}
-				}
+				tryToSubstituteExpr(&aggFunc.Args[i], lp.SCtx(), sc, exprToColumn, tp, x.Schema())
 			}
 		}
 		for i := 0; i < len(x.GroupByItems); i++ {
 			tp = x.GroupByItems[i].GetType().EvalType()
-			for candidateExpr, column := range exprToColumn {
-				if x.GroupByItems[i].Equal(lp.SCtx(), candidateExpr) && candidateExpr.GetType().EvalType() == tp &&
-					x.Schema().ColumnIndex(column) != -1 {
-					x.GroupByItems[i] = column
-				}
+			tryToSubstituteExpr(&x.GroupByItems[i], lp.SCtx(), sc, exprToColumn, tp, x.Schema())
+		}
+		// A HAVING clause that doesn't only reference already-selected columns is evaluated by a
+		// LogicalSelection sitting right above this aggregation; its conditions are handled by the
+		// *LogicalSelection case above using this plan's own schema.
+	case *LogicalJoin:
+		for _, eqCond := range x.EqualConditions {
+			substituteExpression(eqCond, sc, lp.SCtx(), exprToColumn, x.Schema())
+		}
+		for _, cond := range x.LeftConditions {
+			substituteExpression(cond, sc, lp.SCtx(), exprToColumn, x.children[0].Schema())
+		}
+		for _, cond := range x.RightConditions {
+			substituteExpression(cond, sc, lp.SCtx(), exprToColumn, x.children[1].Schema())
+		}
+		for _, cond := range x.OtherConditions {
+			substituteExpression(cond, sc, lp.SCtx(), exprToColumn, x.Schema())
+		}
+	case *LogicalWindow:
+		for _, windowFunc := range x.WindowFuncDescs {
+			for i := 0; i < len(windowFunc.Args); i++ {
+				tp = windowFunc.Args[i].GetType().EvalType()
+				tryToSubstituteExpr(&windowFunc.Args[i], lp.SCtx(), sc, exprToColumn, tp, x.children[0].Schema())
 			}
 		}
+		// PartitionBy and OrderBy items are already resolved to plain columns by the time
+		// LogicalWindow is built (buildWindowFunctions projects any non-column expression below
+		// the window), so the *LogicalProjection case above substitutes them.
 	}
 	for _, child := range lp.Children() {
-		gc.substitute(ctx, child, exprToColumn)
+		gc.substitute(ctx, child, exprToColumn, indexGenCols)
 	}
 	return lp
 }
@@ -0,0 +1,360 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pingcap/tidb/testkit"
+)
+
+// explainContainsIndex reports whether any row of an `explain format='brief'` result mentions idx,
+// i.e. the generated-column index was actually picked for the access path.
+func explainContainsIndex(rows [][]interface{}, idx string) bool {
+	for _, row := range rows {
+		for _, col := range row {
+			if s, ok := col.(string); ok && strings.Contains(s, idx) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestGenerateColumnSubstituteStoredColumn(t *testing.T) {
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("drop table if exists t_gc_stored")
+	tk.MustExec(`create table t_gc_stored (
+		a int,
+		b int,
+		g1 int as (a + b) stored,
+		index idx_g1(g1)
+	)`)
+	tk.MustExec("insert into t_gc_stored(a, b) values (1, 2), (3, 4)")
+	tk.MustExec("analyze table t_gc_stored")
+
+	rows := tk.MustQuery("explain format='brief' select * from t_gc_stored where a + b = 3").Rows()
+	if !explainContainsIndex(rows, "idx_g1") {
+		t.Fatalf("expected a+b = 3 to be substituted to the stored generated column's index, got: %v", rows)
+	}
+}
+
+func TestGenerateColumnSubstituteStoredColumnRefersGeneratedColumn(t *testing.T) {
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("drop table if exists t_gc_chain")
+	tk.MustExec(`create table t_gc_chain (
+		a int,
+		g1 int as (a + 1) virtual,
+		g2 int as (g1 * 2) stored,
+		index idx_g2(g2)
+	)`)
+	tk.MustExec("insert into t_gc_chain(a) values (1), (2)")
+	tk.MustExec("analyze table t_gc_chain")
+
+	rows := tk.MustQuery("explain format='brief' select * from t_gc_chain where (a + 1) * 2 = 4").Rows()
+	if !explainContainsIndex(rows, "idx_g2") {
+		t.Fatalf("expected (a+1)*2 = 4 to be substituted to g2's index even though g2's own "+
+			"expression references the virtual column g1, got: %v", rows)
+	}
+}
+
+func TestGenerateColumnSubstituteStoredColumnCompositeIndex(t *testing.T) {
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("drop table if exists t_gc_stored_composite")
+	tk.MustExec(`create table t_gc_stored_composite (
+		a int,
+		b int,
+		c int,
+		d int,
+		g1 int as (a + b) stored,
+		g2 int as (c * d) stored,
+		index idx_g1_g2(g1, g2)
+	)`)
+	tk.MustExec("insert into t_gc_stored_composite(a, b, c, d) values (1, 2, 3, 4), (5, 6, 7, 8)")
+	tk.MustExec("analyze table t_gc_stored_composite")
+
+	rows := tk.MustQuery("explain format='brief' select * from t_gc_stored_composite " +
+		"where a + b = 3 and c * d = 12").Rows()
+	if !explainContainsIndex(rows, "idx_g1_g2") {
+		t.Fatalf("expected a stored-generated composite index to be usable once both leading "+
+			"columns are substituted, got: %v", rows)
+	}
+}
+
+func TestGenerateColumnSubstituteStoredColumnDecimalScale(t *testing.T) {
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("drop table if exists t_gc_decimal")
+	tk.MustExec(`create table t_gc_decimal (
+		a decimal(10,2),
+		b decimal(10,2),
+		g decimal(12,2) as (a + b) stored,
+		index idx_g(g)
+	)`)
+	tk.MustExec("insert into t_gc_decimal(a, b) values (1.50, 2.25), (3.00, 4.00)")
+	tk.MustExec("analyze table t_gc_decimal")
+
+	// a+b's inferred Flen (11: 8 integer digits, a carry digit and scale 2) is narrower than g's
+	// declared Flen of 12, but its Decimal (scale) still matches g's declared scale exactly, so this
+	// is still a value-preserving substitution despite the Flen mismatch.
+	rows := tk.MustQuery("explain format='brief' select * from t_gc_decimal where a + b = 3.75").Rows()
+	if !explainContainsIndex(rows, "idx_g") {
+		t.Fatalf("expected a+b = 3.75 to be substituted to g despite g's wider Flen, since the "+
+			"decimal scale still matches, got: %v", rows)
+	}
+}
+
+func TestGenerateColumnSubstituteDeclinesDecimalScaleMismatch(t *testing.T) {
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("drop table if exists t_gc_decimal_scale_mismatch")
+	tk.MustExec(`create table t_gc_decimal_scale_mismatch (
+		a decimal(10,2),
+		b decimal(10,2),
+		g decimal(10,2) as (a / b) stored,
+		index idx_g(g)
+	)`)
+	tk.MustExec("insert into t_gc_decimal_scale_mismatch(a, b) values (10.00, 4.00), (9.00, 2.00)")
+	tk.MustExec("analyze table t_gc_decimal_scale_mismatch")
+
+	// Division infers a wider Decimal (scale) than g's declared scale, so the value g actually
+	// stores is rounded from what a/b computes at full precision. Substituting would silently
+	// compare the query against a different value than it asked for, so this must not fire.
+	rows := tk.MustQuery("explain format='brief' select * from t_gc_decimal_scale_mismatch where a / b = 2.5").Rows()
+	if explainContainsIndex(rows, "idx_g") {
+		t.Fatalf("expected a/b to be declined for g since division infers a wider scale than g's "+
+			"declared decimal(10,2), got: %v", rows)
+	}
+}
+
+func TestGenerateColumnSubstituteJoin(t *testing.T) {
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("drop table if exists t_gc_join1, t_gc_join2")
+	tk.MustExec(`create table t_gc_join1 (a int, b int, g int as (a + 1) virtual, index idx_g(g))`)
+	tk.MustExec(`create table t_gc_join2 (c int, d int)`)
+	tk.MustExec("insert into t_gc_join1(a, b) values (1, 1), (2, 2), (3, 3)")
+	tk.MustExec("insert into t_gc_join2(c, d) values (2, 1), (3, 1), (4, 1)")
+	tk.MustExec("analyze table t_gc_join1, t_gc_join2")
+
+	rows := tk.MustQuery("explain format='brief' select /*+ INL_JOIN(t1) */ * from t_gc_join1 t1 " +
+		"join t_gc_join2 t2 on t1.a + 1 = t2.c").Rows()
+	if !explainContainsIndex(rows, "idx_g") {
+		t.Fatalf("expected the join condition t1.a+1 = t2.c to be substituted to t1's generated "+
+			"column index, enabling an IndexJoin, got: %v", rows)
+	}
+}
+
+func TestGenerateColumnSubstituteWindow(t *testing.T) {
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("drop table if exists t_gc_window")
+	tk.MustExec(`create table t_gc_window (
+		a int,
+		b int,
+		g int as (a + 1) virtual,
+		index idx_g(g)
+	)`)
+	tk.MustExec("insert into t_gc_window(a, b) values (1, 10), (2, 20), (3, 30)")
+	tk.MustExec("analyze table t_gc_window")
+
+	rows := tk.MustQuery("explain format='brief' select " +
+		"sum(b) over (partition by a + 1 order by a + 1) from t_gc_window").Rows()
+	if !explainContainsIndex(rows, "idx_g") {
+		t.Fatalf("expected the window function's a+1 argument/partition expression to be "+
+			"substituted to the generated column g, got: %v", rows)
+	}
+}
+
+func TestGenerateColumnSubstituteHaving(t *testing.T) {
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("drop table if exists t_gc_having")
+	tk.MustExec(`create table t_gc_having (
+		a int,
+		b int,
+		g int as (a + 1) virtual,
+		index idx_g(g)
+	)`)
+	tk.MustExec("insert into t_gc_having(a, b) values (1, 10), (2, 20), (3, 30)")
+	tk.MustExec("analyze table t_gc_having")
+
+	rows := tk.MustQuery("explain format='brief' select a, sum(b) from t_gc_having " +
+		"group by a having sum(a + 1) > 0").Rows()
+	if !explainContainsIndex(rows, "idx_g") {
+		t.Fatalf("expected the HAVING clause's sum(a+1) argument to be substituted to the "+
+			"generated column g, got: %v", rows)
+	}
+}
+
+func TestGenerateColumnSubstituteNormalizeCommutative(t *testing.T) {
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("drop table if exists t_gc_commutative")
+	tk.MustExec(`create table t_gc_commutative (
+		a int,
+		b int,
+		g int as (a + b) virtual,
+		index idx_g(g)
+	)`)
+	tk.MustExec("insert into t_gc_commutative(a, b) values (1, 2), (3, 4)")
+	tk.MustExec("analyze table t_gc_commutative")
+
+	// b + a is not in the generated column's own (a + b) order, but normalizeExpr should still
+	// match it.
+	rows := tk.MustQuery("explain format='brief' select * from t_gc_commutative where b + a = 3").Rows()
+	if !explainContainsIndex(rows, "idx_g") {
+		t.Fatalf("expected the reordered expression b+a to be substituted to g (a+b), got: %v", rows)
+	}
+}
+
+func TestGenerateColumnSubstituteNormalizeConstantFold(t *testing.T) {
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("drop table if exists t_gc_fold")
+	tk.MustExec(`create table t_gc_fold (
+		a int,
+		g int as (a + 3) virtual,
+		index idx_g(g)
+	)`)
+	tk.MustExec("insert into t_gc_fold(a) values (1), (2)")
+	tk.MustExec("analyze table t_gc_fold")
+
+	// a + 1 + 2 constant-folds to a + 3, matching g's definition.
+	rows := tk.MustQuery("explain format='brief' select * from t_gc_fold where a + 1 + 2 = 6").Rows()
+	if !explainContainsIndex(rows, "idx_g") {
+		t.Fatalf("expected the constant-foldable expression a+1+2 to be substituted to g (a+3), "+
+			"got: %v", rows)
+	}
+}
+
+func TestGenerateColumnSubstituteCompositeIndexWinCase(t *testing.T) {
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("drop table if exists t_gc_composite_win")
+	tk.MustExec(`create table t_gc_composite_win (
+		a int,
+		b int,
+		c int,
+		d int,
+		e int,
+		g1 int as (a + b) stored,
+		g2 int as (c * d) stored,
+		g3 int as (d + e) stored,
+		index idx_g1_g2_g3(g1, g2, g3)
+	)`)
+	tk.MustExec("insert into t_gc_composite_win(a, b, c, d, e) values (1, 2, 3, 4, 5), (6, 7, 8, 9, 10)")
+	tk.MustExec("analyze table t_gc_composite_win")
+
+	// Only the leading two columns of the index (g1, g2) are matched by the query; that is still a
+	// usable prefix, so the index should be picked even though g3 isn't referenced at all.
+	rows := tk.MustQuery("explain format='brief' select * from t_gc_composite_win " +
+		"where a + b = 3 and c * d = 24").Rows()
+	if !explainContainsIndex(rows, "idx_g1_g2_g3") {
+		t.Fatalf("expected a match on the leading g1,g2 prefix to make idx_g1_g2_g3 usable even "+
+			"without a g3 match, got: %v", rows)
+	}
+}
+
+func TestGenerateColumnSubstituteCompositeIndexDeclinesNonLeadingMatch(t *testing.T) {
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("drop table if exists t_gc_composite_nonleading")
+	tk.MustExec(`create table t_gc_composite_nonleading (
+		a int,
+		b int,
+		c int,
+		d int,
+		g1 int as (a + b) stored,
+		g2 int as (c * d) stored,
+		index idx_g1_g2(g1, g2)
+	)`)
+	tk.MustExec("insert into t_gc_composite_nonleading(a, b, c, d) values (1, 2, 3, 4), (5, 6, 7, 8)")
+	tk.MustExec("analyze table t_gc_composite_nonleading")
+
+	// Only g2, the non-leading index column, is matched; substituting it alone wouldn't make the
+	// index usable (a composite index can't be accessed starting from its second column), so
+	// substituteIndexPrefix must decline the whole candidate and leave idx_g1_g2 unused.
+	rows := tk.MustQuery("explain format='brief' select * from t_gc_composite_nonleading " +
+		"where c * d = 12").Rows()
+	if explainContainsIndex(rows, "idx_g1_g2") {
+		t.Fatalf("expected a match on only the non-leading g2 column to be declined since it can't "+
+			"make idx_g1_g2 usable, got: %v", rows)
+	}
+}
+
+func TestGenerateColumnSubstituteDeclinesLowSelectivityRewrite(t *testing.T) {
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("drop table if exists t_gc_cost")
+	tk.MustExec(`create table t_gc_cost (
+		a int,
+		b int,
+		g int as (a + b) virtual,
+		index idx_g(g)
+	)`)
+	// g only takes the single value 3 for every row, so idx_g has NDV == 1: a lookup through it
+	// would still scan the whole table, making the index substitution strictly worse than the
+	// table scan the optimizer would otherwise pick.
+	tk.MustExec("insert into t_gc_cost(a, b) values (1, 2), (2, 1), (0, 3), (3, 0)")
+	tk.MustExec("analyze table t_gc_cost")
+
+	rows := tk.MustQuery("explain format='brief' select * from t_gc_cost where a + b = 3").Rows()
+	if explainContainsIndex(rows, "idx_g") {
+		t.Fatalf("expected the rewrite to idx_g to be declined since its estimated selectivity is "+
+			"no better than a table scan, got: %v", rows)
+	}
+}
+
+func TestGenerateColumnSubstituteSkipsFloatReassociation(t *testing.T) {
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("drop table if exists t_gc_float")
+	tk.MustExec(`create table t_gc_float (
+		a double,
+		b double,
+		c double,
+		g double as ((a + b) + c) virtual,
+		index idx_g(g)
+	)`)
+	tk.MustExec("insert into t_gc_float(a, b, c) values (1.5, 2.5, 3.5), (0.1, 0.2, 0.3)")
+	tk.MustExec("analyze table t_gc_float")
+
+	// a + (b + c) re-associates (a+b)+c; for DOUBLE operands that can round to a different float64
+	// than the generated column actually stores, so this must NOT be substituted to idx_g.
+	rows := tk.MustQuery("explain format='brief' select * from t_gc_float where a + (b + c) = 7.5").Rows()
+	if explainContainsIndex(rows, "idx_g") {
+		t.Fatalf("expected the re-associated float chain a+(b+c) to be left alone since it can "+
+			"compute a different DOUBLE value than g's (a+b)+c, got: %v", rows)
+	}
+}
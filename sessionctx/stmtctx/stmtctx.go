@@ -0,0 +1,33 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stmtctx
+
+// StatementContext carries state scoped to the statement currently being planned and executed.
+type StatementContext struct {
+	// StmtHints are the query hints attached to the current statement.
+	StmtHints StmtHints
+}
+
+// StmtHints are the per-statement query hints the optimizer consults. Each field here corresponds
+// to one hint name recognized in the /*+ ... */ comment on the statement.
+type StmtHints struct {
+	// NoGCSubstitute is set by the SQL_NO_GC_SUBST hint: it disables gcSubstituter entirely for
+	// this statement.
+	NoGCSubstitute bool
+	// NoCompositeGCSubstitute is set by the SQL_NO_COMPOSITE_GC_SUBST hint: it disables only
+	// gcSubstituter's composite-index-prefix rewrite for this statement, leaving single-column
+	// substitution enabled.
+	NoCompositeGCSubstitute bool
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package variable
+
+import "github.com/pingcap/tidb/sessionctx/stmtctx"
+
+// SessionVars holds the per-session state the optimizer reads from.
+type SessionVars struct {
+	// StmtCtx is the statement context for the statement currently being planned.
+	StmtCtx *stmtctx.StatementContext
+
+	// EnableCompositeGCSubstitution is the tidb_opt_enable_composite_gc_substitution session
+	// variable: it gates whether gcSubstituter may rewrite a LogicalSelection's Conditions against
+	// a composite index's leading generated-column prefix. Defaults to
+	// DefOptEnableCompositeGCSubstitution.
+	EnableCompositeGCSubstitution bool
+
+	// OptGenerateColumnSubstituteSelectivityThreshold is the
+	// tidb_opt_gc_substitute_selectivity_threshold session variable: gcSubstituter only commits a
+	// rewrite to a generated column index when its estimated selectivity is at or below this
+	// fraction of the table. Defaults to DefOptGCSubstituteSelectivityThreshold.
+	OptGenerateColumnSubstituteSelectivityThreshold float64
+}
+
+// NewSessionVars creates a new SessionVars with all system variables set to their defaults.
+func NewSessionVars() *SessionVars {
+	s := &SessionVars{
+		StmtCtx: &stmtctx.StatementContext{},
+	}
+	s.EnableCompositeGCSubstitution = DefOptEnableCompositeGCSubstitution
+	s.OptGenerateColumnSubstituteSelectivityThreshold = DefOptGCSubstituteSelectivityThreshold
+	return s
+}
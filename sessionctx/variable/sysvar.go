@@ -0,0 +1,67 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package variable
+
+import "strconv"
+
+// SysVar represents one system variable the server exposes, in the simplified shape the planner
+// package needs: a name, its default value, and how setting it mutates a session's SessionVars.
+type SysVar struct {
+	// Name is the variable's name as used in `SELECT @@name` / `SET [GLOBAL|SESSION] name = ...`.
+	Name string
+	// Value is the variable's default value, formatted the same way SetSession expects to parse it.
+	Value string
+	// SetSession applies val to s when the variable is set at session scope.
+	SetSession func(s *SessionVars, val string) error
+}
+
+// sysVars is the registry of all system variables that have been registered via RegisterSysVar,
+// keyed by name.
+var sysVars = make(map[string]*SysVar)
+
+// RegisterSysVar adds sv to the global system variable registry. It is meant to be called from an
+// init function in the file that owns the variable.
+func RegisterSysVar(sv *SysVar) {
+	sysVars[sv.Name] = sv
+}
+
+// GetSysVar looks up a previously registered system variable by name, returning nil if it isn't
+// registered.
+func GetSysVar(name string) *SysVar {
+	return sysVars[name]
+}
+
+// boolToOnOff formats a bool the way boolean system variables are conventionally serialized.
+func boolToOnOff(b bool) string {
+	if b {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// tidbOptOn reports whether val represents an enabled boolean system variable value.
+func tidbOptOn(val string) bool {
+	return val == "ON" || val == "1" || val == "on"
+}
+
+// formatFloat formats a float system variable value the way parseFloat expects to read it back.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// parseFloat parses a float system variable value as set via SET [GLOBAL|SESSION] ... = val.
+func parseFloat(val string) (float64, error) {
+	return strconv.ParseFloat(val, 64)
+}
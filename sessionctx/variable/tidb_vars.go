@@ -0,0 +1,63 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package variable
+
+// Session/global system variable names.
+const (
+	// TiDBOptEnableCompositeGCSubstitution is the name of the session/global variable that gates
+	// whether gcSubstituter may rewrite an expression to a composite index's leading
+	// generated-column prefix.
+	TiDBOptEnableCompositeGCSubstitution = "tidb_opt_enable_composite_gc_substitution"
+
+	// TiDBOptGCSubstituteSelectivityThreshold is the name of the session/global variable that
+	// bounds how unselective a generated-column index substitution gcSubstituter is willing to
+	// commit to.
+	TiDBOptGCSubstituteSelectivityThreshold = "tidb_opt_gc_substitute_selectivity_threshold"
+)
+
+// Default values for the system variables above.
+const (
+	// DefOptEnableCompositeGCSubstitution is the default value of
+	// TiDBOptEnableCompositeGCSubstitution.
+	DefOptEnableCompositeGCSubstitution = true
+
+	// DefOptGCSubstituteSelectivityThreshold is the default value of
+	// TiDBOptGCSubstituteSelectivityThreshold: a generated column index substitution is only
+	// committed when it's estimated to select at most 80% of the table.
+	DefOptGCSubstituteSelectivityThreshold = 0.8
+)
+
+func init() {
+	RegisterSysVar(&SysVar{
+		Name:  TiDBOptEnableCompositeGCSubstitution,
+		Value: boolToOnOff(DefOptEnableCompositeGCSubstitution),
+		SetSession: func(s *SessionVars, val string) error {
+			s.EnableCompositeGCSubstitution = tidbOptOn(val)
+			return nil
+		},
+	})
+	RegisterSysVar(&SysVar{
+		Name:  TiDBOptGCSubstituteSelectivityThreshold,
+		Value: formatFloat(DefOptGCSubstituteSelectivityThreshold),
+		SetSession: func(s *SessionVars, val string) error {
+			f, err := parseFloat(val)
+			if err != nil {
+				return err
+			}
+			s.OptGenerateColumnSubstituteSelectivityThreshold = f
+			return nil
+		},
+	})
+}